@@ -0,0 +1,355 @@
+// Package bench is a built-in stand-in for running iperf against the
+// tun11/tun22 pair: it drives tun.StartForwarding with a synthetic traffic
+// generator and measures the result, so throughput/latency comparisons
+// across forwarding strategies don't need an external tool or root
+// privileges to open a real TUN device. This is a deliberate scope
+// narrowing from a literal pair of kernel TUN devices: genDevice/recvDevice
+// stand in for tun11/tun22 so RunMatrix works unprivileged and in CI.
+// TestRealDevicePairSmoke (smoke_linux_test.go) covers the literal pair
+// separately, gated on root rather than run as part of this matrix.
+package bench
+
+import (
+	"encoding/binary"
+	"errors"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"tun_offload/tun"
+)
+
+// headerLen is the size of the flow/seq/timestamp header every generated
+// packet carries ahead of its padding, mirroring how offset reserves room
+// for a virtio_net_hdr in the rest of this package.
+const headerLen = 24
+
+// ipHdrLen/tcpHdrLen/udpHdrLen are the sizes of the no-options IPv4/TCP/UDP
+// headers genDevice crafts ahead of headerLen, so parseTCPFlow/groCoalesce
+// in the tun package have a real packet to work with instead of a bare
+// blob. genDevice frames TCP when offload is requested (GRO/GSO in this
+// module only coalesces TCP) and UDP otherwise.
+const (
+	ipHdrLen  = 20
+	tcpHdrLen = 20
+	udpHdrLen = 8
+
+	protoTCP = 6
+	protoUDP = 17
+
+	tcpFlagACK = 0x10
+)
+
+// maxLatencySamples bounds how many per-packet latencies a recvDevice
+// keeps, so a long high-pps run doesn't grow its sample slice unbounded.
+const maxLatencySamples = 200000
+
+// Config controls one Measure/RunMatrix run.
+type Config struct {
+	Duration   time.Duration
+	PacketSize int
+	Flows      int
+}
+
+// Result holds one (strategy, usePool, offload) cell of the comparison
+// matrix. UsePool only distinguishes cells for tun.StrategyChannel, since
+// that's the only strategy RunMatrix crosses with pooling.
+type Result struct {
+	Strategy  tun.Strategy
+	UsePool   bool
+	Offload   bool
+	Sent      uint64
+	Received  uint64
+	Bytes     uint64
+	Dropped   uint64
+	Reordered uint64
+
+	Duration   time.Duration
+	P50Latency time.Duration
+	P99Latency time.Duration
+}
+
+// PPS returns the measured receive rate in packets per second.
+func (r Result) PPS() float64 {
+	if r.Duration <= 0 {
+		return 0
+	}
+	return float64(r.Received) / r.Duration.Seconds()
+}
+
+// Gbps returns the measured receive rate in gigabits per second.
+func (r Result) Gbps() float64 {
+	if r.Duration <= 0 {
+		return 0
+	}
+	return float64(r.Bytes*8) / r.Duration.Seconds() / 1e9
+}
+
+// errGeneratorDone is returned by genDevice.Read once cfg.Duration has
+// elapsed, so it plugs into forward/readToChannel/readToRing the same way
+// a real device's Read error does: the producer goroutine logs it and
+// exits.
+var errGeneratorDone = errors.New("bench: generator duration elapsed")
+
+// genDevice is a tun.Device whose Read manufactures packets instead of
+// reading them from a kernel interface. Each packet is a crafted
+// IPv4+TCP (offload on, so tun's GRO coalescer has real segments to merge)
+// or IPv4+UDP (offload off) frame whose payload is a headerLen-byte (flow,
+// seq, send time) triple followed by zero padding out to cfg.PacketSize.
+// Write/Close are unused: genDevice only ever stands in for the forwarding
+// source.
+type genDevice struct {
+	cfg      Config
+	deadline time.Time
+	pktSeq   []uint64 // per-flow packet counter embedded in the payload
+	tcpSeq   []uint32 // per-flow TCP byte sequence number, used when offload is on
+	offload  bool
+	sent     uint64
+}
+
+func newGenDevice(cfg Config, offload bool) *genDevice {
+	return &genDevice{
+		cfg:      cfg,
+		deadline: time.Now().Add(cfg.Duration),
+		pktSeq:   make([]uint64, cfg.Flows),
+		tcpSeq:   make([]uint32, cfg.Flows),
+		offload:  offload,
+	}
+}
+
+func (g *genDevice) Read(bufs [][]byte, sizes []int, offset int) (int, error) {
+	if time.Now().After(g.deadline) {
+		return 0, errGeneratorDone
+	}
+	l4HdrLen := udpHdrLen
+	if g.offload {
+		l4HdrLen = tcpHdrLen
+	}
+	hdrLen := ipHdrLen + l4HdrLen
+
+	n := 0
+	for n < len(bufs) {
+		pkt := bufs[n][offset:]
+		size := g.cfg.PacketSize
+		if size > len(pkt) {
+			size = len(pkt)
+		}
+		if size < hdrLen+headerLen {
+			size = hdrLen + headerLen
+		}
+
+		// Flows get contiguous runs of the batch, so same-flow segments
+		// sit next to each other where groCoalesce can merge them.
+		flow := uint64(n * g.cfg.Flows / len(bufs))
+		seq := g.pktSeq[flow]
+		g.pktSeq[flow]++
+		payloadLen := size - hdrLen
+
+		app := pkt[hdrLen:size]
+		binary.BigEndian.PutUint64(app[0:8], flow)
+		binary.BigEndian.PutUint64(app[8:16], seq)
+		binary.BigEndian.PutUint64(app[16:24], uint64(time.Now().UnixNano()))
+
+		// Flow ID doubles as the IP ID so that every segment of a flow
+		// carries the same tcpFlow key groCoalesce merges on; a real stack
+		// would vary it per packet, but nothing here parses it beyond that
+		// equality check.
+		ipID := uint16(flow)
+		if g.offload {
+			writeTCPSegment(pkt[:size], flow, ipID, g.tcpSeq[flow], payloadLen)
+			g.tcpSeq[flow] += uint32(payloadLen)
+		} else {
+			writeUDPSegment(pkt[:size], flow, ipID, payloadLen)
+		}
+
+		sizes[n] = size
+		n++
+	}
+	atomic.AddUint64(&g.sent, uint64(n))
+	return n, nil
+}
+
+// writeTCPSegment fills pkt (offset 0 = IP header start) with a no-options
+// IPv4+TCP frame: ACK set, SYN/FIN/RST clear, so groCoalesce on the
+// receiving end is free to merge consecutive same-flow segments.
+func writeTCPSegment(pkt []byte, flow uint64, ipID uint16, seq uint32, payloadLen int) {
+	writeIPv4Header(pkt[:ipHdrLen], ipID, protoTCP, ipHdrLen+tcpHdrLen+payloadLen)
+
+	tcp := pkt[ipHdrLen : ipHdrLen+tcpHdrLen]
+	binary.BigEndian.PutUint16(tcp[0:2], uint16(20000+flow))
+	binary.BigEndian.PutUint16(tcp[2:4], 9)
+	binary.BigEndian.PutUint32(tcp[4:8], seq)
+	tcp[12] = 5 << 4 // data offset: 5 words, no options
+	tcp[13] = tcpFlagACK
+}
+
+// writeUDPSegment fills pkt (offset 0 = IP header start) with a no-options
+// IPv4+UDP frame.
+func writeUDPSegment(pkt []byte, flow uint64, ipID uint16, payloadLen int) {
+	writeIPv4Header(pkt[:ipHdrLen], ipID, protoUDP, ipHdrLen+udpHdrLen+payloadLen)
+
+	udp := pkt[ipHdrLen : ipHdrLen+udpHdrLen]
+	binary.BigEndian.PutUint16(udp[0:2], uint16(20000+flow))
+	binary.BigEndian.PutUint16(udp[2:4], 9)
+	binary.BigEndian.PutUint16(udp[4:6], uint16(udpHdrLen+payloadLen))
+}
+
+// writeIPv4Header fills a 20-byte no-options IPv4 header. The checksum is
+// left zero: these packets never leave the process, so nothing validates it.
+func writeIPv4Header(b []byte, ipID uint16, protocol byte, totalLen int) {
+	b[0] = 0x45 // version 4, IHL 5
+	binary.BigEndian.PutUint16(b[2:4], uint16(totalLen))
+	binary.BigEndian.PutUint16(b[4:6], ipID)
+	b[8] = 64 // TTL
+	b[9] = protocol
+}
+
+func (g *genDevice) Write(bufs [][]byte, offset int) (int, error) { return len(bufs), nil }
+func (g *genDevice) Close() error                                 { return nil }
+
+// Offloaded reports the offload mode Measure was asked to exercise, the
+// same way a real Linux device reports whether TUNSETOFFLOAD was
+// negotiated.
+func (g *genDevice) Offloaded() bool { return g.offload }
+
+// recvDevice is a tun.Device whose Write records arrivals instead of
+// handing them to a kernel interface, tracking per-flow sequence numbers
+// to estimate drops and reordering. Read/Close are unused: recvDevice only
+// ever stands in for the forwarding destination.
+type recvDevice struct {
+	offload bool
+
+	mu        sync.Mutex
+	lastSeq   map[uint64]uint64
+	latencies []time.Duration
+	packets   uint64
+	bytes     uint64
+	dropped   uint64
+	reordered uint64
+}
+
+func newRecvDevice(offload bool) *recvDevice {
+	return &recvDevice{offload: offload, lastSeq: make(map[uint64]uint64)}
+}
+
+func (r *recvDevice) Write(bufs [][]byte, offset int) (int, error) {
+	now := time.Now()
+
+	l4HdrLen := udpHdrLen
+	if r.offload {
+		l4HdrLen = tcpHdrLen
+	}
+	hdrLen := ipHdrLen + l4HdrLen
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, buf := range bufs {
+		pkt := buf[offset:]
+		if len(pkt) < hdrLen+headerLen {
+			continue
+		}
+		payload := pkt[hdrLen:]
+		flow := binary.BigEndian.Uint64(payload[0:8])
+		seq := binary.BigEndian.Uint64(payload[8:16])
+		sentNanos := int64(binary.BigEndian.Uint64(payload[16:24]))
+
+		r.packets++
+		r.bytes += uint64(len(pkt))
+		if len(r.latencies) < maxLatencySamples {
+			r.latencies = append(r.latencies, now.Sub(time.Unix(0, sentNanos)))
+		}
+
+		last, seen := r.lastSeq[flow]
+		switch {
+		case !seen:
+			r.lastSeq[flow] = seq
+		case seq <= last:
+			r.reordered++
+		default:
+			if gap := seq - last - 1; gap > 0 {
+				r.dropped += gap
+			}
+			r.lastSeq[flow] = seq
+		}
+	}
+	return len(bufs), nil
+}
+
+func (r *recvDevice) Read(bufs [][]byte, sizes []int, offset int) (int, error) { return 0, nil }
+func (r *recvDevice) Close() error                                             { return nil }
+func (r *recvDevice) Offloaded() bool                                          { return r.offload }
+
+// Measure runs one forwarding strategy for cfg.Duration against a
+// synthetic generator/receiver pair and reports the observed throughput
+// and latency. usePool and offload are forwarded to tun.StartForwarding
+// unchanged; offload only takes effect for tun.StrategyDirect.
+func Measure(strategy tun.Strategy, usePool bool, offload bool, cfg Config) Result {
+	gen := newGenDevice(cfg, offload)
+	recv := newRecvDevice(offload)
+
+	tun.StartForwarding(strategy, gen, recv, usePool, offload)
+
+	// Give in-flight packets a little extra time to land after the
+	// generator stops producing, so the tail of the run isn't counted as
+	// drops.
+	const drain = 250 * time.Millisecond
+	time.Sleep(cfg.Duration + drain)
+
+	recv.mu.Lock()
+	defer recv.mu.Unlock()
+	return Result{
+		Strategy:   strategy,
+		UsePool:    usePool,
+		Offload:    offload,
+		Sent:       atomic.LoadUint64(&gen.sent),
+		Received:   recv.packets,
+		Bytes:      recv.bytes,
+		Dropped:    recv.dropped,
+		Reordered:  recv.reordered,
+		Duration:   cfg.Duration,
+		P50Latency: percentile(recv.latencies, 0.50),
+		P99Latency: percentile(recv.latencies, 0.99),
+	}
+}
+
+// matrixCell is one (strategy, pool) column of RunMatrix's comparison
+// table; offload is crossed in separately since it only applies to direct
+// forwarding.
+type matrixCell struct {
+	strategy tun.Strategy
+	usePool  bool
+}
+
+// RunMatrix runs Measure across {direct, channel, channel+pool, ring},
+// crossed with offload on/off where offload is actually implemented
+// (tun.StrategyDirect only; see tun.StartForwarding).
+func RunMatrix(cfg Config) []Result {
+	cells := []matrixCell{
+		{tun.StrategyDirect, false},
+		{tun.StrategyChannel, false},
+		{tun.StrategyChannel, true}, // channel+pool
+		{tun.StrategyRing, false},
+	}
+
+	var results []Result
+	for _, c := range cells {
+		for _, offload := range []bool{false, true} {
+			if offload && c.strategy != tun.StrategyDirect {
+				continue
+			}
+			results = append(results, Measure(c.strategy, c.usePool, offload, cfg))
+		}
+	}
+	return results
+}
+
+func percentile(samples []time.Duration, p float64) time.Duration {
+	if len(samples) == 0 {
+		return 0
+	}
+	sorted := append([]time.Duration(nil), samples...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	idx := int(p * float64(len(sorted)-1))
+	return sorted[idx]
+}
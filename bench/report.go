@@ -0,0 +1,24 @@
+package bench
+
+import "fmt"
+
+// label names a Result's strategy column, distinguishing plain channel
+// forwarding from channel+pool since both share tun.StrategyChannel.
+func (r Result) label() string {
+	if r.UsePool {
+		return r.Strategy.String() + "+pool"
+	}
+	return r.Strategy.String()
+}
+
+// PrintMatrix prints one row per Result, in the order RunMatrix produced
+// them, as a fixed-width table.
+func PrintMatrix(results []Result) {
+	fmt.Printf("%-14s %-7s %12s %10s %10s %10s %10s %10s %9s\n",
+		"strategy", "offload", "pps", "gbps", "p50", "p99", "sent", "recv", "drop/reord")
+	for _, r := range results {
+		fmt.Printf("%-14s %-7t %12.0f %10.3f %10s %10s %10d %10d %4d/%-4d\n",
+			r.label(), r.Offload, r.PPS(), r.Gbps(), r.P50Latency, r.P99Latency,
+			r.Sent, r.Received, r.Dropped, r.Reordered)
+	}
+}
@@ -3,7 +3,10 @@ package main
 import (
 	"log"
 	"os"
+	"strconv"
 	"strings"
+	"time"
+	"tun_offload/bench"
 	"tun_offload/normal_tun"
 	"tun_offload/tun"
 )
@@ -12,6 +15,11 @@ func main() {
 	mode := ""
 	useChannel := false
 	usePool := false
+	offload := false
+	useRing := false
+	duration := 30 * time.Second
+	packetSize := 1400
+	flows := 8
 
 	for _, arg := range os.Args[1:] {
 		if strings.HasPrefix(arg, "-mode=") {
@@ -20,13 +28,39 @@ func main() {
 			useChannel = true
 		} else if arg == "-usePool" {
 			usePool = true
+		} else if arg == "-offload" {
+			offload = true
+		} else if arg == "-useRing" {
+			useRing = true
+		} else if strings.HasPrefix(arg, "-duration=") {
+			if d, err := time.ParseDuration(strings.TrimPrefix(arg, "-duration=")); err == nil {
+				duration = d
+			} else {
+				log.Printf("Invalid -duration=%q, keeping default %s: %v", strings.TrimPrefix(arg, "-duration="), duration, err)
+			}
+		} else if strings.HasPrefix(arg, "-packetSize=") {
+			if n, err := strconv.Atoi(strings.TrimPrefix(arg, "-packetSize=")); err == nil {
+				packetSize = n
+			} else {
+				log.Printf("Invalid -packetSize=%q, keeping default %d: %v", strings.TrimPrefix(arg, "-packetSize="), packetSize, err)
+			}
+		} else if strings.HasPrefix(arg, "-flows=") {
+			if n, err := strconv.Atoi(strings.TrimPrefix(arg, "-flows=")); err == nil {
+				flows = n
+			} else {
+				log.Printf("Invalid -flows=%q, keeping default %d: %v", strings.TrimPrefix(arg, "-flows="), flows, err)
+			}
 		}
 	}
 
 	if mode == "normal" {
 		normal_tun.Run(useChannel)
+	} else if mode == "bench" {
+		results := bench.RunMatrix(bench.Config{Duration: duration, PacketSize: packetSize, Flows: flows})
+		bench.PrintMatrix(results)
+		return
 	} else {
-		tun.Run(useChannel, usePool)
+		tun.Run(useChannel, usePool, offload, useRing)
 	}
 
 	log.Println("Tun forward started. Press Ctrl+C to exit.")
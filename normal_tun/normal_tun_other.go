@@ -0,0 +1,14 @@
+//go:build !linux
+
+package normal_tun
+
+import "log"
+
+// Run is unimplemented outside Linux: this package shells out to `ip` to
+// configure interfaces (see setupNetwork in normal_tun.go) and was never
+// converted to the portable tun.Device abstraction, so -mode=normal has
+// nothing to fall back to here. This stub exists only so main.go's
+// unconditional import of the package still builds on other platforms.
+func Run(useChannel bool) {
+	log.Fatal("normal_tun mode (-mode=normal) is only supported on Linux; use the default tun.Run mode instead")
+}
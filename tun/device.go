@@ -0,0 +1,13 @@
+package tun
+
+// Device is the batched I/O surface that tun.Run needs from a TUN
+// interface. Packet i of a batch lives at bufs[i][offset : offset+sizes[i]];
+// the bytes in [0:offset) are reserved for an optional virtio_net_hdr so
+// that offload metadata can travel alongside the payload without a second
+// allocation. CreateTUN and configureInterface are implemented per-platform
+// in tun_linux.go, tun_darwin.go, tun_windows.go and tun_freebsd.go.
+type Device interface {
+	Read(bufs [][]byte, sizes []int, offset int) (n int, err error)
+	Write(bufs [][]byte, offset int) (n int, err error)
+	Close() error
+}
@@ -0,0 +1,41 @@
+package tun
+
+import (
+	"errors"
+	"strings"
+)
+
+// ErrTooManySegments is returned by Read when the kernel has a coalesced
+// (GSO) super-frame ready that does not fit in the caller-provided buffer
+// (e.g. a super-frame larger than the MTU-sized buffers forward reads
+// into).
+var ErrTooManySegments = errors.New("tun: coalesced frame overflows read buffer")
+
+// ErrorBatch collects the per-packet errors from a single Device.Write call
+// over a batch of packets, so callers can see exactly which indices failed
+// without losing the rest of the batch to a single aggregate error.
+type ErrorBatch []error
+
+// Error implements the error interface, listing every failure with its
+// packet index.
+func (b ErrorBatch) Error() string {
+	var sb strings.Builder
+	sb.WriteString("tun: batched write errors:")
+	for _, err := range b {
+		sb.WriteString(" ")
+		sb.WriteString(err.Error())
+		sb.WriteString(";")
+	}
+	return sb.String()
+}
+
+// Is reports whether target matches any individual error in the batch,
+// so callers can still do errors.Is(err, someSentinel) against a batch.
+func (b ErrorBatch) Is(target error) bool {
+	for _, err := range b {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
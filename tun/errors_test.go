@@ -0,0 +1,40 @@
+package tun
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestErrorBatchError(t *testing.T) {
+	batch := ErrorBatch{errors.New("packet 0: boom"), errors.New("packet 2: kaboom")}
+	msg := batch.Error()
+	if msg == "" {
+		t.Fatal("Error() returned an empty string")
+	}
+	for _, want := range []string{"packet 0: boom", "packet 2: kaboom"} {
+		if !strings.Contains(msg, want) {
+			t.Errorf("Error() = %q, want it to contain %q", msg, want)
+		}
+	}
+}
+
+func TestErrorBatchIs(t *testing.T) {
+	sentinel := errors.New("sentinel")
+	batch := ErrorBatch{errors.New("unrelated"), sentinel}
+
+	if !errors.Is(batch, sentinel) {
+		t.Error("errors.Is(batch, sentinel) = false, want true")
+	}
+	if errors.Is(batch, errors.New("sentinel")) {
+		t.Error("errors.Is matched a distinct error with the same message")
+	}
+}
+
+func TestErrorBatchIsWrapped(t *testing.T) {
+	batch := ErrorBatch{errors.New("packet 0: other"), fmt.Errorf("packet 1: %w", ErrTooManySegments)}
+	if !errors.Is(batch, ErrTooManySegments) {
+		t.Error("errors.Is(batch, ErrTooManySegments) = false, want true")
+	}
+}
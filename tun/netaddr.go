@@ -0,0 +1,28 @@
+package tun
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+)
+
+// parseCIDR validates a bare prefix length such as "24", as used by
+// configureInterface's cidr argument.
+func parseCIDR(cidr string) (int, error) {
+	n, err := strconv.Atoi(cidr)
+	if err != nil || n < 0 || n > 32 {
+		return 0, fmt.Errorf("invalid prefix length %q", cidr)
+	}
+	return n, nil
+}
+
+// parseIPv4 parses a dotted-quad IPv4 address into its 4-byte form.
+func parseIPv4(ip string) ([4]byte, error) {
+	var out [4]byte
+	parsed := net.ParseIP(ip).To4()
+	if parsed == nil {
+		return out, fmt.Errorf("invalid IPv4 address %q", ip)
+	}
+	copy(out[:], parsed)
+	return out, nil
+}
@@ -0,0 +1,114 @@
+//go:build linux
+
+package tun
+
+import (
+	"encoding/binary"
+	"fmt"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// ifAddrMsg mirrors struct ifaddrmsg from linux/if_addr.h.
+type ifAddrMsg struct {
+	Family    uint8
+	Prefixlen uint8
+	Flags     uint8
+	Scope     uint8
+	Index     uint32
+}
+
+// ifInfoMsg mirrors struct ifinfomsg from linux/rtnetlink.h.
+type ifInfoMsg struct {
+	Family uint8
+	_      uint8
+	Type   uint16
+	Index  int32
+	Flags  uint32
+	Change uint32
+}
+
+// Address attribute types from linux/if_addr.h.
+const (
+	ifaAddress = 1
+	ifaLocal   = 2
+)
+
+// nlAttr appends a netlink attribute (type rtaType, 4-byte value v) to buf,
+// including its own padding.
+func nlAttr(buf []byte, rtaType uint16, v []byte) []byte {
+	attrLen := 4 + len(v)
+	hdr := make([]byte, 4)
+	binary.LittleEndian.PutUint16(hdr[0:2], uint16(attrLen))
+	binary.LittleEndian.PutUint16(hdr[2:4], rtaType)
+	buf = append(buf, hdr...)
+	buf = append(buf, v...)
+	for len(buf)%4 != 0 {
+		buf = append(buf, 0)
+	}
+	return buf
+}
+
+// sendNetlinkRequest sends msg (a complete, length-prefixed nlmsghdr +
+// payload) requesting an ack, and returns an error if the kernel NAKed it.
+func sendNetlinkRequest(sock int, msg []byte) error {
+	if err := unix.Send(sock, msg, 0); err != nil {
+		return err
+	}
+	reply := make([]byte, unix.Getpagesize())
+	n, err := unix.Read(sock, reply)
+	if err != nil {
+		return err
+	}
+	if n < 16 {
+		return fmt.Errorf("short netlink reply (%d bytes)", n)
+	}
+	msgType := binary.LittleEndian.Uint16(reply[4:6])
+	if msgType != unix.NLMSG_ERROR {
+		return nil
+	}
+	errno := int32(binary.LittleEndian.Uint32(reply[16:20]))
+	if errno == 0 {
+		return nil
+	}
+	return unix.Errno(-errno)
+}
+
+func netlinkAddAddr(sock int, ifindex uint32, addr [4]byte, prefixLen int) error {
+	ifa := ifAddrMsg{
+		Family:    unix.AF_INET,
+		Prefixlen: uint8(prefixLen),
+		Scope:     unix.RT_SCOPE_UNIVERSE,
+		Index:     ifindex,
+	}
+
+	payload := (*(*[unsafe.Sizeof(ifa)]byte)(unsafe.Pointer(&ifa)))[:]
+	payload = nlAttr(append([]byte{}, payload...), ifaLocal, addr[:])
+	payload = nlAttr(payload, ifaAddress, addr[:]) // tun is point-to-point, so local == peer
+
+	return sendNetlinkMessage(sock, unix.RTM_NEWADDR, unix.NLM_F_CREATE|unix.NLM_F_ACK|unix.NLM_F_REPLACE, payload)
+}
+
+func netlinkLinkUp(sock int, ifindex uint32) error {
+	ifi := ifInfoMsg{
+		Family: unix.AF_UNSPEC,
+		Index:  int32(ifindex),
+		Flags:  unix.IFF_UP,
+		Change: unix.IFF_UP,
+	}
+	payload := (*(*[unsafe.Sizeof(ifi)]byte)(unsafe.Pointer(&ifi)))[:]
+
+	return sendNetlinkMessage(sock, unix.RTM_NEWLINK, unix.NLM_F_ACK, append([]byte{}, payload...))
+}
+
+func sendNetlinkMessage(sock int, msgType uint16, flags uint16, payload []byte) error {
+	hdr := make([]byte, 16)
+	binary.LittleEndian.PutUint32(hdr[0:4], uint32(16+len(payload)))
+	binary.LittleEndian.PutUint16(hdr[4:6], msgType)
+	binary.LittleEndian.PutUint16(hdr[6:8], unix.NLM_F_REQUEST|flags)
+	// Sequence/PID are left zero; this package only ever has one request
+	// in flight per netlink socket, so the kernel's default ack is enough.
+	msg := append(hdr, payload...)
+	return sendNetlinkRequest(sock, msg)
+}
@@ -0,0 +1,316 @@
+//go:build linux
+
+package tun
+
+import "encoding/binary"
+
+// virtioNetHdrLen is the wire size of a struct virtio_net_hdr with no
+// mergeable-buffer extension. It doubles as the packet `offset` used
+// throughout this package, so every buffer already has room for one.
+const virtioNetHdrLen = 10
+
+// virtio_net_hdr.gso_type values (see linux/virtio_net.h).
+const (
+	gsoNone  = 0x0
+	gsoTCPv4 = 0x1
+	gsoTCPv6 = 0x4
+
+	flagNeedsCsum = 0x1
+)
+
+// virtioNetHdr mirrors struct virtio_net_hdr. It is encoded/decoded
+// manually rather than via binary.Read/Write so it can live directly in
+// the leading bytes of a packet buffer with no extra allocation.
+type virtioNetHdr struct {
+	flags      uint8
+	gsoType    uint8
+	hdrLen     uint16
+	gsoSize    uint16
+	csumStart  uint16
+	csumOffset uint16
+}
+
+func (h *virtioNetHdr) decode(b []byte) {
+	h.flags = b[0]
+	h.gsoType = b[1]
+	h.hdrLen = binary.LittleEndian.Uint16(b[2:4])
+	h.gsoSize = binary.LittleEndian.Uint16(b[4:6])
+	h.csumStart = binary.LittleEndian.Uint16(b[6:8])
+	h.csumOffset = binary.LittleEndian.Uint16(b[8:10])
+}
+
+func (h *virtioNetHdr) encode(b []byte) {
+	b[0] = h.flags
+	b[1] = h.gsoType
+	binary.LittleEndian.PutUint16(b[2:4], h.hdrLen)
+	binary.LittleEndian.PutUint16(b[4:6], h.gsoSize)
+	binary.LittleEndian.PutUint16(b[6:8], h.csumStart)
+	binary.LittleEndian.PutUint16(b[8:10], h.csumOffset)
+}
+
+// tcpFlow identifies the parts of an IPv4/IPv6+TCP packet that must match
+// for two segments to be coalesced into one GRO super-frame.
+type tcpFlow struct {
+	isV6      bool
+	ipHdrLen  int
+	tcpHdrLen int
+	srcPort   uint16
+	dstPort   uint16
+	ipID      uint16 // IPv4 only
+}
+
+// parseTCPFlow inspects pkt (starting at the IP header, i.e. buf[offset:])
+// and returns the flow plus the byte offset where the TCP payload begins.
+// ok is false for anything that isn't a plain IPv4/IPv6 TCP segment.
+func parseTCPFlow(pkt []byte) (flow tcpFlow, payloadStart int, seq uint32, ackFlags byte, ok bool) {
+	if len(pkt) < 20 {
+		return
+	}
+	version := pkt[0] >> 4
+	switch version {
+	case 4:
+		ihl := int(pkt[0]&0x0f) * 4
+		if ihl < 20 || len(pkt) < ihl+20 || pkt[9] != 6 /* TCP */ {
+			return
+		}
+		flow.ipHdrLen = ihl
+		flow.ipID = binary.BigEndian.Uint16(pkt[4:6])
+		tcpOff := ihl
+		tcpHdrLen := int(pkt[tcpOff+12]>>4) * 4
+		if tcpHdrLen < 20 || len(pkt) < tcpOff+tcpHdrLen {
+			return
+		}
+		flow.tcpHdrLen = tcpHdrLen
+		flow.srcPort = binary.BigEndian.Uint16(pkt[tcpOff : tcpOff+2])
+		flow.dstPort = binary.BigEndian.Uint16(pkt[tcpOff+2 : tcpOff+4])
+		seq = binary.BigEndian.Uint32(pkt[tcpOff+4 : tcpOff+8])
+		ackFlags = pkt[tcpOff+13]
+		return flow, tcpOff + tcpHdrLen, seq, ackFlags, true
+	case 6:
+		if len(pkt) < 40 || pkt[6] != 6 /* next header TCP */ {
+			return
+		}
+		flow.isV6 = true
+		flow.ipHdrLen = 40
+		tcpOff := 40
+		if len(pkt) < tcpOff+20 {
+			return
+		}
+		tcpHdrLen := int(pkt[tcpOff+12]>>4) * 4
+		if tcpHdrLen < 20 || len(pkt) < tcpOff+tcpHdrLen {
+			return
+		}
+		flow.tcpHdrLen = tcpHdrLen
+		flow.srcPort = binary.BigEndian.Uint16(pkt[tcpOff : tcpOff+2])
+		flow.dstPort = binary.BigEndian.Uint16(pkt[tcpOff+2 : tcpOff+4])
+		seq = binary.BigEndian.Uint32(pkt[tcpOff+4 : tcpOff+8])
+		ackFlags = pkt[tcpOff+13]
+		return flow, tcpOff + tcpHdrLen, seq, ackFlags, true
+	default:
+		return
+	}
+}
+
+const (
+	tcpFlagFIN = 0x01
+	tcpFlagSYN = 0x02
+	tcpFlagRST = 0x04
+	tcpFlagACK = 0x10
+)
+
+// groCoalesce merges adjacent same-flow TCP segments in bufs[:n] in place
+// and returns the resulting (smaller-or-equal) packet count. Each buffer
+// that absorbed a merge is annotated with a virtio_net_hdr describing it so the
+// eventual consumer (gsoSplit, or a kernel that understands GSO) can either
+// split it back apart or hand it straight to hardware TSO.
+func groCoalesce(bufs [][]byte, sizes []int, offset, n int) int {
+	out := 0
+	// gsoSize is the payload length of the first segment merged into
+	// bufs[mergedInto], kept as gso_size for every later merge into it.
+	mergedInto, gsoSize := -1, 0
+	for i := 0; i < n; i++ {
+		pkt := bufs[i][offset : offset+sizes[i]]
+		flow, payloadStart, seq, flags, ok := parseTCPFlow(pkt)
+		payloadLen := len(pkt) - payloadStart
+
+		if ok && out > 0 {
+			pi := out - 1
+			prevPkt := bufs[pi][offset : offset+sizes[pi]]
+			prevFlow, prevPayloadStart, prevSeq, _, prevOK := parseTCPFlow(prevPkt)
+			prevPayloadLen := len(prevPkt) - prevPayloadStart
+
+			canMerge := prevOK &&
+				flow == prevFlow &&
+				flags&(tcpFlagSYN|tcpFlagFIN|tcpFlagRST) == 0 &&
+				flags&tcpFlagACK != 0 &&
+				prevSeq+uint32(prevPayloadLen) == seq &&
+				payloadLen > 0 &&
+				cap(bufs[pi]) >= offset+sizes[pi]+payloadLen
+
+			if canMerge {
+				if mergedInto != pi {
+					mergedInto, gsoSize = pi, prevPayloadLen
+				}
+				copy(bufs[pi][offset+sizes[pi]:], pkt[payloadStart:])
+				sizes[pi] += payloadLen
+
+				var hdr virtioNetHdr
+				hdr.flags = flagNeedsCsum
+				if flow.isV6 {
+					hdr.gsoType = gsoTCPv6
+				} else {
+					hdr.gsoType = gsoTCPv4
+				}
+				hdr.hdrLen = uint16(prevFlow.ipHdrLen + prevFlow.tcpHdrLen)
+				hdr.gsoSize = uint16(gsoSize)
+				hdr.csumStart = uint16(prevFlow.ipHdrLen)
+				hdr.csumOffset = 16 // offset of the TCP checksum field within the TCP header
+				hdr.encode(bufs[pi][:virtioNetHdrLen])
+				continue
+			}
+		}
+
+		if i != out {
+			// This also carries bufs[i]'s own freshly-read [0:offset) header
+			// bytes forward, so an unmerged passthrough packet always keeps
+			// whatever gso_type the kernel itself set on read rather than
+			// having it overwritten here.
+			copy(bufs[out], bufs[i][:offset+sizes[i]])
+			sizes[out] = sizes[i]
+		}
+		out++
+	}
+	return out
+}
+
+// gsoSplit undoes groCoalesce: if bufs[offset:offset+size]'s leading
+// virtio_net_hdr carries a non-zero gso_type, it rewrites the header of
+// each segment (IP total length / ID, TCP sequence number, checksums) and
+// writes the segments into out[i][offset:...], the same layout Device.Write
+// expects, recording each segment's payload length (excluding offset) into
+// outSizes, and returns the number produced. It returns 0 without touching
+// out when the frame needs no splitting, so the caller can fall back to
+// writing buf as-is.
+//
+// The split stops once it has filled len(out)/len(outSizes) slots even if
+// the super-frame's payload isn't fully consumed yet: a groCoalesce merge
+// can pack dozens of segments into one buffer (bounded only by its cap),
+// far more than a caller sized for the common case may have room for. The
+// remaining, unwritten tail of the super-frame is dropped rather than
+// overrunning out; callers that hit this should size out/outSizes to the
+// batch they actually forward.
+func gsoSplit(buf []byte, size, offset int, mtu int, out [][]byte, outSizes []int) int {
+	var hdr virtioNetHdr
+	hdr.decode(buf[:virtioNetHdrLen])
+	if hdr.gsoType == gsoNone {
+		return 0
+	}
+
+	pkt := buf[offset : offset+size]
+	ipHdrLen := int(hdr.csumStart)
+	tcpHdrLen := int(hdr.hdrLen) - ipHdrLen
+	if tcpHdrLen < 20 || len(pkt) < ipHdrLen+tcpHdrLen {
+		return 0
+	}
+
+	payload := pkt[ipHdrLen+tcpHdrLen:]
+	segSize := int(hdr.gsoSize)
+	if segSize <= 0 || segSize > mtu {
+		segSize = mtu - ipHdrLen - tcpHdrLen
+	}
+
+	baseSeq := binary.BigEndian.Uint32(pkt[ipHdrLen+4 : ipHdrLen+8])
+	baseID := uint16(0)
+	isV6 := hdr.gsoType == gsoTCPv6
+	if !isV6 {
+		baseID = binary.BigEndian.Uint16(pkt[2:4])
+	}
+
+	maxSegments := len(out)
+	if len(outSizes) < maxSegments {
+		maxSegments = len(outSizes)
+	}
+
+	segments := 0
+	for off := 0; off < len(payload) && segments < maxSegments; off += segSize {
+		end := off + segSize
+		if end > len(payload) {
+			end = len(payload)
+		}
+		segPayload := payload[off:end]
+		segLen := ipHdrLen + tcpHdrLen + len(segPayload)
+
+		seg := out[segments][offset : offset+segLen]
+		copy(seg[:ipHdrLen+tcpHdrLen], pkt[:ipHdrLen+tcpHdrLen])
+		copy(seg[ipHdrLen+tcpHdrLen:], segPayload)
+
+		binary.BigEndian.PutUint32(seg[ipHdrLen+4:ipHdrLen+8], baseSeq+uint32(off))
+		// Only the final segment keeps FIN; interior ones are pure data.
+		if end != len(payload) {
+			seg[ipHdrLen+13] &^= tcpFlagFIN
+		}
+
+		if isV6 {
+			binary.BigEndian.PutUint16(seg[4:6], uint16(tcpHdrLen+len(segPayload)))
+		} else {
+			binary.BigEndian.PutUint16(seg[2:4], uint16(segLen))
+			binary.BigEndian.PutUint16(seg[4:6], baseID+uint16(segments))
+			seg[10], seg[11] = 0, 0
+			ipsum := checksum(seg[:ipHdrLen])
+			seg[10] = byte(ipsum >> 8)
+			seg[11] = byte(ipsum)
+		}
+
+		seg[ipHdrLen+16], seg[ipHdrLen+17] = 0, 0
+		tsum := tcpChecksum(seg[:ipHdrLen], seg[ipHdrLen:], isV6)
+		seg[ipHdrLen+16] = byte(tsum >> 8)
+		seg[ipHdrLen+17] = byte(tsum)
+
+		outSizes[segments] = segLen
+		segments++
+	}
+	return segments
+}
+
+// checksum computes the IPv4 header (ones'-complement) checksum.
+func checksum(b []byte) uint16 {
+	var sum uint32
+	for i := 0; i+1 < len(b); i += 2 {
+		sum += uint32(b[i])<<8 | uint32(b[i+1])
+	}
+	if len(b)%2 == 1 {
+		sum += uint32(b[len(b)-1]) << 8
+	}
+	for sum>>16 != 0 {
+		sum = sum&0xffff + sum>>16
+	}
+	return ^uint16(sum)
+}
+
+// tcpChecksum computes the TCP checksum over ipHdr's pseudo-header plus
+// tcpSeg (TCP header + payload).
+func tcpChecksum(ipHdr, tcpSeg []byte, isV6 bool) uint16 {
+	var sum uint32
+	if isV6 {
+		for i := 8; i < 40; i += 2 { // src+dst addrs
+			sum += uint32(ipHdr[i])<<8 | uint32(ipHdr[i+1])
+		}
+	} else {
+		for i := 12; i < 20; i += 2 { // src+dst addrs
+			sum += uint32(ipHdr[i])<<8 | uint32(ipHdr[i+1])
+		}
+	}
+	sum += 6 // protocol = TCP
+	sum += uint32(len(tcpSeg))
+
+	for i := 0; i+1 < len(tcpSeg); i += 2 {
+		sum += uint32(tcpSeg[i])<<8 | uint32(tcpSeg[i+1])
+	}
+	if len(tcpSeg)%2 == 1 {
+		sum += uint32(tcpSeg[len(tcpSeg)-1]) << 8
+	}
+	for sum>>16 != 0 {
+		sum = sum&0xffff + sum>>16
+	}
+	return ^uint16(sum)
+}
@@ -0,0 +1,18 @@
+//go:build !linux
+
+package tun
+
+// groCoalesce and gsoSplit have no implementation outside Linux: none of
+// the other platform backends in this package negotiate TUNSETOFFLOAD (see
+// tun_darwin.go, tun_freebsd.go, tun_windows.go), so deviceOffloaded never
+// returns true for them. A Device that does report Offloaded() true (such
+// as the bench package's synthetic devices) still takes forward's
+// offloadEnabled branch here, where these stubs pass every packet through
+// unchanged.
+func groCoalesce(bufs [][]byte, sizes []int, offset, n int) int {
+	return n
+}
+
+func gsoSplit(buf []byte, size, offset int, mtu int, out [][]byte, outSizes []int) int {
+	return 0
+}
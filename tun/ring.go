@@ -0,0 +1,69 @@
+package tun
+
+import (
+	"runtime"
+	"sync/atomic"
+)
+
+// ringCapacity is the number of packet slots in a packetRing. It must be a
+// power of two so index wrapping can use a mask instead of a modulo.
+const ringCapacity = 2048
+
+// cacheLinePadding is sized to push a packetRing's head and tail cursors
+// onto separate cache lines, so the producer's stores to head and the
+// consumer's stores to tail don't false-share.
+type cacheLinePadding [64 - 8]byte
+
+// packetRing is a fixed-capacity single-producer/single-consumer ring
+// buffer of packet values, used in place of a chan packet so readToRing/
+// writeFromRing can move packets without the channel's internal mutex or a
+// per-send heap allocation. It is NOT safe for multiple producers or
+// multiple consumers.
+type packetRing struct {
+	buf  [ringCapacity]packet
+	mask uint64
+
+	head uint64 // producer-owned: next slot index to write
+	_    cacheLinePadding
+	tail uint64 // consumer-owned: next slot index to read
+	_    cacheLinePadding
+}
+
+func newPacketRing() *packetRing {
+	return &packetRing{mask: ringCapacity - 1}
+}
+
+// push blocks (spinning/yielding) until there is room, then stores pkt.
+func (r *packetRing) push(pkt packet) {
+	head := atomic.LoadUint64(&r.head)
+	for head-atomic.LoadUint64(&r.tail) >= ringCapacity {
+		runtime.Gosched()
+	}
+	r.buf[head&r.mask] = pkt
+	atomic.StoreUint64(&r.head, head+1)
+}
+
+// drain copies up to len(out) queued packets into out and returns how many
+// were copied, blocking (spinning/yielding) until at least one is
+// available.
+func (r *packetRing) drain(out []packet) int {
+	var head, tail uint64
+	for {
+		tail = atomic.LoadUint64(&r.tail)
+		head = atomic.LoadUint64(&r.head)
+		if head != tail {
+			break
+		}
+		runtime.Gosched()
+	}
+
+	n := int(head - tail)
+	if n > len(out) {
+		n = len(out)
+	}
+	for i := 0; i < n; i++ {
+		out[i] = r.buf[(tail+uint64(i))&r.mask]
+	}
+	atomic.StoreUint64(&r.tail, tail+uint64(n))
+	return n
+}
@@ -0,0 +1,95 @@
+package tun
+
+import (
+	"errors"
+	"log"
+)
+
+// readToRing is the ring-buffer analogue of readToChannel: a producer that
+// reads batches from src and pushes each packet onto r, reusing the same
+// pool logic readToChannel does.
+func readToRing(src Device, r *packetRing) {
+	readBufs := make([][]byte, batchSize)
+	sizes := make([]int, batchSize)
+	for i := 0; i < batchSize; i++ {
+		readBufs[i] = make([]byte, mtuSize+offset)
+	}
+
+	usePool := packetBufferPool != nil
+
+	for {
+		n, err := src.Read(readBufs, sizes, offset)
+		if err != nil {
+			if errors.Is(err, ErrTooManySegments) {
+				log.Printf("Read error: %v; dropping this batch and continuing", err)
+				continue
+			}
+			log.Printf("Read error, exiting goroutine: %v", err)
+			return
+		}
+
+		for i := 0; i < n; i++ {
+			var pktBuf []byte
+			if usePool {
+				pktBuf = packetBufferPool.Get().([]byte)
+			} else {
+				pktBuf = make([]byte, maxPktSize)
+			}
+
+			size := sizes[i] + offset
+			if cap(pktBuf) < size {
+				log.Printf("Warning: Buffer obtained is too small. Allocating new.")
+				pktBuf = make([]byte, size)
+			}
+
+			copy(pktBuf, readBufs[i][:size])
+			r.push(packet{buf: pktBuf, size: size})
+		}
+	}
+}
+
+// writeFromRing is the ring-buffer analogue of writeFromChannel: a
+// consumer that drains up to batchSize packets from r in one pass and
+// writes them to dst in a single batched call.
+func writeFromRing(dst Device, r *packetRing) {
+	pkts := make([]packet, batchSize)
+	bufs := make([][]byte, batchSize)
+	originalBufs := make([][]byte, batchSize)
+
+	usePool := packetBufferPool != nil
+	for {
+		n := r.drain(pkts)
+
+		for i := 0; i < n; i++ {
+			bufs[i] = pkts[i].buf[:pkts[i].size]
+			if usePool {
+				originalBufs[i] = pkts[i].buf
+			}
+		}
+
+		_, err := dst.Write(bufs[:n], offset)
+		logWriteError(err)
+
+		if usePool {
+			for i := 0; i < n; i++ {
+				packetBufferPool.Put(originalBufs[i])
+			}
+		}
+	}
+}
+
+// forwardWithRing sets up the producer/consumer goroutines for ring-based
+// forwarding. Pool initialization mirrors forwardWithChannel's.
+func forwardWithRing(src, dst Device, usePool bool) {
+	if usePool && packetBufferPool == nil {
+		packetBufferPool = newPacketBufferPool()
+		log.Println("Initialized sync.Pool for packet buffers.")
+	} else if !usePool && packetBufferPool != nil {
+		packetBufferPool = nil
+		log.Println("Disabled sync.Pool for packet buffers.")
+	}
+
+	r := newPacketRing()
+	go readToRing(src, r)
+	go writeFromRing(dst, r)
+}
@@ -1,10 +1,10 @@
 package tun
 
 import (
+	"errors"
 	"fmt"
 	"log"
 	"os"
-	"os/exec"
 	"os/signal"
 	"sync"
 	"syscall"
@@ -23,32 +23,9 @@ const (
 // This will be initialized once if pooling is enabled.
 var packetBufferPool *sync.Pool // Changed to a pointer to allow conditional initialization
 
-// --- Helper Functions (No changes needed) ---
-
-func runCmd(name string, args ...string) error {
-	cmd := exec.Command(name, args...)
-	out, err := cmd.CombinedOutput()
-	if err != nil {
-		return fmt.Errorf("runCmd: %s %v: %v: %s", name, args, err, out)
-	}
-	return nil
-}
-
-func setupTun(name, ip, cidr string) error {
-	addr := fmt.Sprintf("%s/%s", ip, cidr)
-	if err := runCmd("ip", "addr", "add", addr, "dev", name); err != nil {
-		return err
-	}
-	if err := runCmd("ip", "link", "set", "dev", name, "up"); err != nil {
-		return err
-	}
-	log.Printf("Successfully set up TUN device %s with address %s", name, addr)
-	return nil
-}
-
 // --- Direct Forwarding (Original Method, with context) ---
 
-func forward(src, dst Device) {
+func forward(src, dst Device, offloadEnabled bool) {
 	const bufLen = mtuSize + offset
 
 	bufs := make([][]byte, batchSize)
@@ -58,12 +35,29 @@ func forward(src, dst Device) {
 		bufs[i] = make([]byte, maxPktSize)
 	}
 
+	// splitBufs/splitLens are scratch space gsoSplit writes MTU-sized
+	// segments into when a coalesced super-frame needs to come back apart
+	// before reaching dst.
+	var splitBufs [][]byte
+	var splitLens []int
+	if offloadEnabled {
+		splitBufs = make([][]byte, batchSize)
+		splitLens = make([]int, batchSize)
+		for i := range splitBufs {
+			splitBufs[i] = make([]byte, mtuSize+offset)
+		}
+	}
+
 	for {
 		for i := 0; i < batchSize; i++ {
 			bufs[i] = bufs[i][:bufLen]
 		}
 		n, err := src.Read(bufs, sizes, offset)
 		if err != nil {
+			if errors.Is(err, ErrTooManySegments) {
+				log.Printf("Read error: %v; dropping this batch and continuing", err)
+				continue
+			}
 			log.Printf("Read error, exiting goroutine: %v", err)
 			return
 		}
@@ -71,14 +65,54 @@ func forward(src, dst Device) {
 			continue
 		}
 
+		if offloadEnabled {
+			n = groCoalesce(bufs, sizes, offset, n)
+		}
+
+		outN := 0
 		for i := 0; i < n; i++ {
-			outBufs[i] = bufs[i][:sizes[i]+offset]
+			if outN >= batchSize {
+				// A coalesced super-frame can gsoSplit into far more
+				// segments than one batch's worth of input packets (it's
+				// bounded only by cap(bufs[pi]), not batchSize); once
+				// outBufs is full, stop rather than overrun it and drop
+				// whatever remains of this read batch.
+				log.Printf("forward: split output filled batch of %d; dropping remainder of this read batch", batchSize)
+				break
+			}
+			if offloadEnabled {
+				base := outN
+				if segs := gsoSplit(bufs[i], sizes[i], offset, mtuSize, splitBufs[base:], splitLens[base:]); segs > 0 {
+					for s := 0; s < segs; s++ {
+						outBufs[outN] = splitBufs[base+s][:offset+splitLens[base+s]]
+						outN++
+					}
+					continue
+				}
+			}
+			outBufs[outN] = bufs[i][:sizes[i]+offset]
+			outN++
 		}
-		_, err = dst.Write(outBufs[:n], offset)
-		if err != nil {
-			log.Printf("Write error: %v", err)
+		_, err = dst.Write(outBufs[:outN], offset)
+		logWriteError(err)
+	}
+}
+
+// logWriteError reports a Write failure. If err is an ErrorBatch, each
+// packet's failure is logged individually with its index rather than
+// collapsing the whole batch into one opaque message.
+func logWriteError(err error) {
+	if err == nil {
+		return
+	}
+	var batch ErrorBatch
+	if errors.As(err, &batch) {
+		for _, e := range batch {
+			log.Printf("Write error: %v", e)
 		}
+		return
 	}
+	log.Printf("Write error: %v", err)
 }
 
 // --- Channel-based Forwarding (Modified for conditional pooling) ---
@@ -102,6 +136,10 @@ func readToChannel(src Device, ch chan<- packet) {
 	for {
 		n, err := src.Read(readBufs, sizes, offset)
 		if err != nil {
+			if errors.Is(err, ErrTooManySegments) {
+				log.Printf("Read error: %v; dropping this batch and continuing", err)
+				continue
+			}
 			log.Printf("Read error, exiting goroutine: %v", err)
 			return
 		}
@@ -159,9 +197,7 @@ func writeFromChannel(dst Device, ch <-chan packet) {
 
 		if n > 0 {
 			_, err := dst.Write(bufs[:n], offset)
-			if err != nil {
-				log.Printf("Write error: %v", err)
-			}
+			logWriteError(err)
 
 			// IMPORTANT: Conditionally return all used buffers to the pool.
 			if usePool {
@@ -174,6 +210,16 @@ func writeFromChannel(dst Device, ch <-chan packet) {
 	}
 }
 
+// newPacketBufferPool builds the sync.Pool shared by the channel- and
+// ring-based forwarding paths.
+func newPacketBufferPool() *sync.Pool {
+	return &sync.Pool{
+		New: func() interface{} {
+			return make([]byte, maxPktSize)
+		},
+	}
+}
+
 // forwardWithChannel sets up the goroutines for channel-based forwarding.
 // The usePool parameter now controls the global packetBufferPool initialization.
 func forwardWithChannel(src, dst Device, usePool bool) {
@@ -184,11 +230,7 @@ func forwardWithChannel(src, dst Device, usePool bool) {
 		// and you want to strictly guarantee single initialization.
 		// For this specific setup (Run calls it once per direction),
 		// a simple nil check is sufficient.
-		packetBufferPool = &sync.Pool{
-			New: func() interface{} {
-				return make([]byte, maxPktSize)
-			},
-		}
+		packetBufferPool = newPacketBufferPool()
 		log.Println("Initialized sync.Pool for packet buffers.")
 	} else if !usePool && packetBufferPool != nil {
 		// If we're turning off pooling, clear the global pool reference
@@ -203,39 +245,134 @@ func forwardWithChannel(src, dst Device, usePool bool) {
 	go writeFromChannel(dst, channel)
 }
 
+// deviceOffloaded reports whether d negotiated TUNSETOFFLOAD with the
+// kernel, for platforms/devices that support it. Devices that don't
+// implement the check (e.g. the darwin, freebsd and windows backends) are treated as
+// non-offloaded.
+func deviceOffloaded(d Device) bool {
+	type offloadReporter interface {
+		Offloaded() bool
+	}
+	if o, ok := d.(offloadReporter); ok {
+		return o.Offloaded()
+	}
+	return false
+}
+
 // --- Main Execution Logic (Modified) ---
 
-func Run(useChannel bool, usePool bool) { // Added usePool parameter
-	log.Printf("Starting TUN forwarding demo (useChannel: %t, usePool: %t)", useChannel, usePool)
+// Strategy selects which forwarding implementation StartForwarding uses.
+type Strategy int
+
+const (
+	StrategyDirect Strategy = iota
+	StrategyChannel
+	StrategyRing
+)
+
+func (s Strategy) String() string {
+	switch s {
+	case StrategyDirect:
+		return "direct"
+	case StrategyChannel:
+		return "channel"
+	case StrategyRing:
+		return "ring"
+	default:
+		return "unknown"
+	}
+}
 
-	tun1, err := CreateTUN("tun11", mtuSize)
+// SetupPair creates the tun11/tun22 device pair used by Run and by the
+// bench subsystem, configuring their addresses the same way in both
+// cases. The returned cleanup closes both devices.
+func SetupPair(offload bool) (tun1, tun2 Device, cleanup func(), err error) {
+	tun1, err = CreateTUN("tun11", mtuSize, offload)
 	if err != nil {
-		log.Fatalf("Failed to create tun11: %v", err)
+		return nil, nil, nil, fmt.Errorf("create tun11: %w", err)
 	}
-	defer tun1.Close()
-	if err := setupTun("tun11", "10.0.1.1", "24"); err != nil {
-		log.Fatalf("Failed to setup tun11: %v", err)
+	if err := configureInterface("tun11", "10.0.1.1", "24", mtuSize); err != nil {
+		tun1.Close()
+		return nil, nil, nil, fmt.Errorf("configure tun11: %w", err)
 	}
 
-	tun2, err := CreateTUN("tun22", mtuSize)
+	tun2, err = CreateTUN("tun22", mtuSize, offload)
 	if err != nil {
-		log.Fatalf("Failed to create tun22: %v", err)
+		tun1.Close()
+		return nil, nil, nil, fmt.Errorf("create tun22: %w", err)
 	}
-	defer tun2.Close()
-	if err := setupTun("tun22", "10.0.2.1", "24"); err != nil {
-		log.Fatalf("Failed to setup tun22: %v", err)
+	if err := configureInterface("tun22", "10.0.2.1", "24", mtuSize); err != nil {
+		tun1.Close()
+		tun2.Close()
+		return nil, nil, nil, fmt.Errorf("configure tun22: %w", err)
 	}
 
-	if useChannel {
+	cleanup = func() {
+		tun1.Close()
+		tun2.Close()
+	}
+	return tun1, tun2, cleanup, nil
+}
+
+// StartForwarding launches the forwarding goroutines for strategy between
+// src and dst and reports whether offload was actually negotiated; offload
+// is only implemented for StrategyDirect, so other strategies always
+// report false.
+func StartForwarding(strategy Strategy, src, dst Device, usePool bool, offload bool) bool {
+	switch strategy {
+	case StrategyChannel:
+		forwardWithChannel(src, dst, usePool)
+		return false
+	case StrategyRing:
+		forwardWithRing(src, dst, usePool)
+		return false
+	default:
+		negotiated := offload && deviceOffloaded(src) && deviceOffloaded(dst)
+		go forward(src, dst, negotiated)
+		return negotiated
+	}
+}
+
+func Run(useChannel bool, usePool bool, offload bool, useRing bool) { // Added usePool parameter
+	log.Printf("Starting TUN forwarding demo (useChannel: %t, usePool: %t, offload: %t, useRing: %t)", useChannel, usePool, offload, useRing)
+
+	strategy := StrategyDirect
+	switch {
+	case useRing:
+		strategy = StrategyRing
+		if useChannel {
+			log.Println("-useRing takes precedence over -useChannel; using the ring-based path.")
+		}
+	case useChannel:
+		strategy = StrategyChannel
+	}
+	if strategy != StrategyDirect && offload {
+		log.Println("Offload is only implemented for direct forwarding; disabling it for this run.")
+		offload = false
+	}
+
+	tun1, tun2, cleanup, err := SetupPair(offload)
+	if err != nil {
+		log.Fatalf("Failed to set up tun pair: %v", err)
+	}
+	defer cleanup()
+
+	switch strategy {
+	case StrategyChannel:
 		log.Println("Using channel-based forwarding.")
-		// The forwardWithChannel now handles the global pool initialization/clearing
-		forwardWithChannel(tun1, tun2, usePool) // Pass usePool here
-		forwardWithChannel(tun2, tun1, usePool) // And here
-	} else {
-		log.Println("Using direct forwarding (sync.Pool not applicable here).")
-		// For direct forwarding, the usePool parameter has no effect as it doesn't use the channel-based logic.
-		go forward(tun1, tun2)
-		go forward(tun2, tun1)
+		StartForwarding(StrategyChannel, tun1, tun2, usePool, false)
+		StartForwarding(StrategyChannel, tun2, tun1, usePool, false)
+	case StrategyRing:
+		log.Println("Using ring-based forwarding.")
+		StartForwarding(StrategyRing, tun1, tun2, usePool, false)
+		StartForwarding(StrategyRing, tun2, tun1, usePool, false)
+	default:
+		negotiated := StartForwarding(StrategyDirect, tun1, tun2, usePool, offload)
+		StartForwarding(StrategyDirect, tun2, tun1, usePool, offload)
+		if offload && !negotiated {
+			log.Println("Kernel did not accept TUNSETOFFLOAD on one or both interfaces; falling back to non-offload forwarding.")
+		}
+		log.Printf("Using direct forwarding (sync.Pool not applicable here, offload: %t).", negotiated)
 	}
 
 	log.Println("Forwarding started. Press Ctrl+C to exit.")
@@ -246,4 +383,3 @@ func Run(useChannel bool, usePool bool) { // Added usePool parameter
 
 	log.Println("Shutdown complete.")
 }
-
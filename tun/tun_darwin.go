@@ -0,0 +1,265 @@
+//go:build darwin
+
+package tun
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// Darwin has no /dev/net/tun; a TUN interface is obtained by connecting a
+// PF_SYSTEM/SYSPROTO_CONTROL socket to the kernel's "com.apple.net.utun_control"
+// control and letting the kernel pick (or request) a utunN unit number.
+const (
+	sysprotoControl = 2
+	utunControlName = "com.apple.net.utun_control"
+	afSysControl    = 2
+	maxKctlName     = 96
+)
+
+// ifReq/ifReqMTU mirror struct ifreq as BSD declares it: a 16-byte name
+// followed by a union, here interpreted as either a short (ifr_flags) or
+// an int (ifr_mtu). This differs from Linux's layout, hence its own copy
+// per platform instead of a shared definition.
+type ifReq struct {
+	Name  [16]byte
+	Flags uint16
+	pad   [14]byte
+}
+
+type ifReqMTU struct {
+	Name [16]byte
+	MTU  int32
+	pad  [12]byte
+}
+
+// inAliasReq mirrors struct in_aliasreq from <netinet/in_var.h>, used by
+// SIOCAIFADDR to assign a point-to-point IPv4 address to a utun interface.
+type inAliasReq struct {
+	Name     [16]byte
+	Addr     unix.RawSockaddrInet4
+	DstAddr  unix.RawSockaddrInet4
+	MaskAddr unix.RawSockaddrInet4
+}
+
+func ipv4Sockaddr(ip string) (unix.RawSockaddrInet4, error) {
+	var sa unix.RawSockaddrInet4
+	addr, err := parseIPv4(ip)
+	if err != nil {
+		return sa, err
+	}
+	sa.Len = uint8(unsafe.Sizeof(sa))
+	sa.Family = unix.AF_INET
+	sa.Addr = addr
+	return sa, nil
+}
+
+func aliasAddr(sock int, name, ip, cidr string) error {
+	addr, err := ipv4Sockaddr(ip)
+	if err != nil {
+		return err
+	}
+	prefixLen, err := parseCIDR(cidr)
+	if err != nil {
+		return err
+	}
+	mask := uint32(0xffffffff) << uint(32-prefixLen)
+	maskAddr := unix.RawSockaddrInet4{
+		Len:    uint8(unsafe.Sizeof(unix.RawSockaddrInet4{})),
+		Family: unix.AF_INET,
+		Addr:   [4]byte{byte(mask >> 24), byte(mask >> 16), byte(mask >> 8), byte(mask)},
+	}
+
+	var req inAliasReq
+	copy(req.Name[:], name)
+	req.Addr = addr
+	req.DstAddr = addr // point-to-point: peer == local, matching this package's tun11/tun22 setup
+	req.MaskAddr = maskAddr
+
+	if _, _, errno := unix.Syscall(unix.SYS_IOCTL, uintptr(sock), unix.SIOCAIFADDR, uintptr(unsafe.Pointer(&req))); errno != 0 {
+		return fmt.Errorf("ioctl(SIOCAIFADDR) %s: %w", name, errno)
+	}
+	return nil
+}
+
+type ctlInfo struct {
+	ID   uint32
+	Name [maxKctlName]byte
+}
+
+type sockaddrCtl struct {
+	Len      uint8
+	Family   uint8
+	SysAddr  uint16
+	ID       uint32
+	Unit     uint32
+	Reserved [5]uint32
+}
+
+// darwinTUN wraps a connected utun control socket. Every read/write on it
+// is prefixed with a 4-byte AF_INET/AF_INET6 family header that the kernel
+// uses in place of the Linux IFF_NO_PI flag.
+type darwinTUN struct {
+	fd   int
+	name string
+}
+
+func CreateTUN(name string, mtu int, offload bool) (Device, error) {
+	if offload {
+		log.Printf("Offload is not implemented on darwin; ignoring -offload for %s", name)
+	}
+
+	fd, err := unix.Socket(unix.AF_SYSTEM, unix.SOCK_DGRAM, sysprotoControl)
+	if err != nil {
+		return nil, fmt.Errorf("open utun control socket: %w", err)
+	}
+
+	var info ctlInfo
+	copy(info.Name[:], utunControlName)
+	if err := ctlInfoIoctl(fd, &info); err != nil {
+		unix.Close(fd)
+		return nil, fmt.Errorf("CTLIOCGINFO: %w", err)
+	}
+
+	unit, err := utunUnit(name)
+	if err != nil {
+		unix.Close(fd)
+		return nil, err
+	}
+
+	addr := sockaddrCtl{
+		Len:    uint8(unsafe.Sizeof(sockaddrCtl{})),
+		Family: afSysControl,
+		ID:     info.ID,
+		Unit:   unit + 1, // utun0 -> unit 1, matching the kernel's numbering
+	}
+	if _, _, errno := unix.Syscall(unix.SYS_CONNECT, uintptr(fd),
+		uintptr(unsafe.Pointer(&addr)), unsafe.Sizeof(addr)); errno != 0 {
+		unix.Close(fd)
+		return nil, fmt.Errorf("connect utun control socket: %w", errno)
+	}
+
+	if err := configureInterface(name, "", "", mtu); err != nil {
+		// This early call sets the MTU and up flag with no address; the
+		// address is assigned by the later configureInterface call in
+		// SetupPair, so a failure here is not fatal to device creation.
+		log.Printf("Warning: could not pre-set MTU on %s: %v", name, err)
+	}
+
+	return &darwinTUN{fd: fd, name: name}, nil
+}
+
+// utunUnit extracts N from a "tunN" name; darwin's utun numbering has no
+// relation to the name used elsewhere in this package, so we reuse the
+// trailing digits as the unit request.
+func utunUnit(name string) (uint32, error) {
+	for i := len(name); i > 0; i-- {
+		if name[i-1] < '0' || name[i-1] > '9' {
+			n, err := strconv.Atoi(name[i:])
+			if err != nil {
+				return 0, fmt.Errorf("cannot derive utun unit from %q: %w", name, err)
+			}
+			return uint32(n), nil
+		}
+	}
+	return 0, fmt.Errorf("cannot derive utun unit from %q", name)
+}
+
+func ctlInfoIoctl(fd int, info *ctlInfo) error {
+	const CTLIOCGINFO = 0xc0644e03
+	_, _, errno := unix.Syscall(unix.SYS_IOCTL, uintptr(fd), CTLIOCGINFO, uintptr(unsafe.Pointer(info)))
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+func (t *darwinTUN) Read(bufs [][]byte, sizes []int, offset int) (int, error) {
+	// utun frames are prefixed with a 4-byte address-family header instead
+	// of the virtio_net_hdr space other platforms use; read it into the
+	// same reserved region so offset math elsewhere stays unchanged.
+	n, err := unix.Read(t.fd, bufs[0])
+	if err != nil {
+		return 0, err
+	}
+	const familyHdrLen = 4
+	if n < familyHdrLen {
+		sizes[0] = 0
+		return 1, nil
+	}
+	copy(bufs[0][offset-familyHdrLen:], bufs[0][:n])
+	if n < familyHdrLen {
+		sizes[0] = 0
+	} else {
+		sizes[0] = n - familyHdrLen
+	}
+	return 1, nil
+}
+
+func (t *darwinTUN) Write(bufs [][]byte, offset int) (int, error) {
+	const familyHdrLen = 4
+	var errs ErrorBatch
+	written := 0
+	for i, buf := range bufs {
+		packet := buf[offset-familyHdrLen:]
+		family := uint32(unix.AF_INET)
+		if offset < len(packet) && packet[familyHdrLen]>>4 == 6 {
+			family = unix.AF_INET6
+		}
+		packet[0] = byte(family >> 24)
+		packet[1] = byte(family >> 16)
+		packet[2] = byte(family >> 8)
+		packet[3] = byte(family)
+		if _, err := unix.Write(t.fd, packet); err != nil {
+			errs = append(errs, fmt.Errorf("packet %d: %w", i, err))
+			continue
+		}
+		written++
+	}
+	if len(errs) > 0 {
+		return written, errs
+	}
+	return written, nil
+}
+
+func (t *darwinTUN) Close() error {
+	return unix.Close(t.fd)
+}
+
+// configureInterface assigns ip/cidr to name, sets its MTU and brings it up
+// via SIOCAIFADDR/SIOCSIFMTU/SIOCSIFFLAGS on an AF_INET socket rather than
+// shelling out to ifconfig.
+func configureInterface(name, ip, cidr string, mtu int) error {
+	sock, err := unix.Socket(unix.AF_INET, unix.SOCK_DGRAM, 0)
+	if err != nil {
+		return fmt.Errorf("socket: %w", err)
+	}
+	defer unix.Close(sock)
+
+	var req ifReqMTU
+	copy(req.Name[:], name)
+	req.MTU = int32(mtu)
+	if _, _, errno := unix.Syscall(unix.SYS_IOCTL, uintptr(sock), unix.SIOCSIFMTU, uintptr(unsafe.Pointer(&req))); errno != 0 {
+		return fmt.Errorf("ioctl(SIOCSIFMTU) %s: %w", name, errno)
+	}
+
+	if ip != "" {
+		if err := aliasAddr(sock, name, ip, cidr); err != nil {
+			return err
+		}
+	}
+
+	var flagsReq ifReq
+	copy(flagsReq.Name[:], name)
+	flagsReq.Flags = unix.IFF_UP
+	if _, _, errno := unix.Syscall(unix.SYS_IOCTL, uintptr(sock), unix.SIOCSIFFLAGS, uintptr(unsafe.Pointer(&flagsReq))); errno != 0 {
+		return fmt.Errorf("ioctl(SIOCSIFFLAGS) %s: %w", name, errno)
+	}
+
+	log.Printf("Successfully set up TUN device %s with address %s/%s", name, ip, cidr)
+	return nil
+}
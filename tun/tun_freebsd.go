@@ -0,0 +1,153 @@
+//go:build freebsd
+
+package tun
+
+import (
+	"fmt"
+	"log"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// freebsdTUN wraps a /dev/tunN character device. Unlike Linux's
+// /dev/net/tun, here the device node itself names the interface, and
+// IFF_NO_PI-equivalent behaviour is the default (no link-layer framing).
+type freebsdTUN struct {
+	fd   int
+	name string
+}
+
+// ifReq/ifReqMTU mirror FreeBSD's struct ifreq: a 16-byte name followed by
+// a union interpreted here as either a short (ifr_flags) or an int
+// (ifr_mtu), matching this package's Linux/Darwin equivalents.
+type ifReq struct {
+	Name  [16]byte
+	Flags uint16
+	pad   [14]byte
+}
+
+type ifReqMTU struct {
+	Name [16]byte
+	MTU  int32
+	pad  [12]byte
+}
+
+// inAliasReq mirrors struct in_aliasreq, used by SIOCAIFADDR to assign a
+// point-to-point IPv4 address to a tun interface.
+type inAliasReq struct {
+	Name     [16]byte
+	Addr     unix.RawSockaddrInet4
+	DstAddr  unix.RawSockaddrInet4
+	MaskAddr unix.RawSockaddrInet4
+}
+
+func CreateTUN(name string, mtu int, offload bool) (Device, error) {
+	if offload {
+		log.Printf("Offload is not implemented on freebsd; ignoring -offload for %s", name)
+	}
+
+	fd, err := unix.Open("/dev/"+name, unix.O_RDWR, 0)
+	if err != nil {
+		return nil, fmt.Errorf("open /dev/%s: %w", name, err)
+	}
+
+	if err := setMTU(name, mtu); err != nil {
+		unix.Close(fd)
+		return nil, err
+	}
+
+	return &freebsdTUN{fd: fd, name: name}, nil
+}
+
+func setMTU(name string, mtu int) error {
+	sock, err := unix.Socket(unix.AF_INET, unix.SOCK_DGRAM, 0)
+	if err != nil {
+		return fmt.Errorf("socket for SIOCSIFMTU: %w", err)
+	}
+	defer unix.Close(sock)
+
+	var req ifReqMTU
+	copy(req.Name[:], name)
+	req.MTU = int32(mtu)
+	if _, _, errno := unix.Syscall(unix.SYS_IOCTL, uintptr(sock), unix.SIOCSIFMTU, uintptr(unsafe.Pointer(&req))); errno != 0 {
+		return fmt.Errorf("ioctl(SIOCSIFMTU) %s: %w", name, errno)
+	}
+	return nil
+}
+
+// Read reads one packet into bufs[0][offset:]: /dev/tunN never prepends any
+// header (no offload, no PI), so unlike Linux's virtio_net_hdr case, the
+// kernel bytes themselves start the payload rather than buf index 0.
+func (t *freebsdTUN) Read(bufs [][]byte, sizes []int, offset int) (int, error) {
+	n, err := unix.Read(t.fd, bufs[0][offset:])
+	if err != nil {
+		return 0, err
+	}
+	sizes[0] = n
+	return 1, nil
+}
+
+func (t *freebsdTUN) Write(bufs [][]byte, offset int) (int, error) {
+	var errs ErrorBatch
+	written := 0
+	for i, buf := range bufs {
+		if _, err := unix.Write(t.fd, buf[offset:]); err != nil {
+			errs = append(errs, fmt.Errorf("packet %d: %w", i, err))
+			continue
+		}
+		written++
+	}
+	if len(errs) > 0 {
+		return written, errs
+	}
+	return written, nil
+}
+
+func (t *freebsdTUN) Close() error {
+	return unix.Close(t.fd)
+}
+
+// configureInterface assigns ip/cidr to name and brings it up via
+// SIOCAIFADDR/SIOCSIFFLAGS on an AF_INET socket instead of shelling out to
+// ifconfig. mtu is ignored: CreateTUN already set it via setMTU.
+func configureInterface(name, ip, cidr string, mtu int) error {
+	sock, err := unix.Socket(unix.AF_INET, unix.SOCK_DGRAM, 0)
+	if err != nil {
+		return fmt.Errorf("socket: %w", err)
+	}
+	defer unix.Close(sock)
+
+	addr, err := parseIPv4(ip)
+	if err != nil {
+		return err
+	}
+	prefixLen, err := parseCIDR(cidr)
+	if err != nil {
+		return err
+	}
+	mask := uint32(0xffffffff) << uint(32-prefixLen)
+
+	var req inAliasReq
+	copy(req.Name[:], name)
+	req.Addr = unix.RawSockaddrInet4{Len: uint8(unsafe.Sizeof(unix.RawSockaddrInet4{})), Family: unix.AF_INET, Addr: addr}
+	req.DstAddr = req.Addr // point-to-point: peer == local, matching this package's tun11/tun22 setup
+	req.MaskAddr = unix.RawSockaddrInet4{
+		Len:    uint8(unsafe.Sizeof(unix.RawSockaddrInet4{})),
+		Family: unix.AF_INET,
+		Addr:   [4]byte{byte(mask >> 24), byte(mask >> 16), byte(mask >> 8), byte(mask)},
+	}
+	if _, _, errno := unix.Syscall(unix.SYS_IOCTL, uintptr(sock), unix.SIOCAIFADDR, uintptr(unsafe.Pointer(&req))); errno != 0 {
+		return fmt.Errorf("ioctl(SIOCAIFADDR) %s: %w", name, errno)
+	}
+
+	var flagsReq ifReq
+	copy(flagsReq.Name[:], name)
+	flagsReq.Flags = unix.IFF_UP
+	if _, _, errno := unix.Syscall(unix.SYS_IOCTL, uintptr(sock), unix.SIOCSIFFLAGS, uintptr(unsafe.Pointer(&flagsReq))); errno != 0 {
+		return fmt.Errorf("ioctl(SIOCSIFFLAGS) %s: %w", name, errno)
+	}
+
+	log.Printf("Successfully set up TUN device %s with address %s/%s", name, ip, cidr)
+	return nil
+}
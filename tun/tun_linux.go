@@ -0,0 +1,314 @@
+//go:build linux
+
+package tun
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+const (
+	cIFF_TUN      = 0x0001
+	cIFF_NO_PI    = 0x1000
+	cIFF_VNET_HDR = 0x4000
+
+	cTUNSETIFF       = 0x400454ca
+	cTUNSETVNETHDRSZ = 0x400454d8
+	cTUNSETOFFLOAD   = 0x400454d0
+	tunOffloadCsum   = 0x01 // TUN_F_CSUM
+	tunOffloadTSO4   = 0x02 // TUN_F_TSO4
+	tunOffloadTSO6   = 0x04 // TUN_F_TSO6
+)
+
+type ifReq struct {
+	Name  [16]byte
+	Flags uint16
+	pad   [22]byte
+}
+
+// ifReqMTU mirrors the same struct ifreq as ifReq, but with the union
+// interpreted as ifr_mtu (an int) the way SIOCSIFMTU expects it instead of
+// ifr_flags (a short), as TUNSETIFF expects.
+type ifReqMTU struct {
+	Name [16]byte
+	MTU  int32
+	pad  [20]byte
+}
+
+// linuxTUN is a /dev/net/tun backed Device; offloadEnabled records whether
+// IFF_VNET_HDR and TUNSETOFFLOAD were negotiated for it.
+type linuxTUN struct {
+	fd             int
+	name           string
+	offloadEnabled bool
+}
+
+// CreateTUN opens a TUN interface named name and brings its MTU up to mtu.
+// When offload is true it additionally requests IFF_VNET_HDR and tries to
+// negotiate TUNSETOFFLOAD with the kernel; if the kernel rejects either
+// call, CreateTUN falls back to the plain (non-offload) device rather than
+// failing outright.
+func CreateTUN(name string, mtu int, offload bool) (Device, error) {
+	fd, err := unix.Open("/dev/net/tun", unix.O_RDWR, 0)
+	if err != nil {
+		return nil, fmt.Errorf("open /dev/net/tun: %w", err)
+	}
+
+	var req ifReq
+	copy(req.Name[:], name)
+	req.Flags = cIFF_TUN | cIFF_NO_PI
+	if offload {
+		req.Flags |= cIFF_VNET_HDR
+	}
+
+	if _, _, errno := unix.Syscall(unix.SYS_IOCTL, uintptr(fd), cTUNSETIFF, uintptr(unsafe.Pointer(&req))); errno != 0 {
+		if offload {
+			// Retry without IFF_VNET_HDR; some kernels/drivers reject it.
+			req.Flags &^= cIFF_VNET_HDR
+			if _, _, errno2 := unix.Syscall(unix.SYS_IOCTL, uintptr(fd), cTUNSETIFF, uintptr(unsafe.Pointer(&req))); errno2 != 0 {
+				unix.Close(fd)
+				return nil, fmt.Errorf("ioctl(TUNSETIFF) %s: %w", name, errno2)
+			}
+			offload = false
+		} else {
+			unix.Close(fd)
+			return nil, fmt.Errorf("ioctl(TUNSETIFF) %s: %w", name, errno)
+		}
+	}
+
+	// Read batches multiple already-queued packets per call via non-blocking
+	// reads (see linuxTUN.Read), so the fd must not block once something is
+	// queued.
+	if err := unix.SetNonblock(fd, true); err != nil {
+		unix.Close(fd)
+		return nil, fmt.Errorf("set nonblocking %s: %w", name, err)
+	}
+
+	t := &linuxTUN{fd: fd, name: name}
+
+	if offload {
+		if _, _, errno := unix.Syscall(unix.SYS_IOCTL, uintptr(fd), cTUNSETVNETHDRSZ, uintptr(unsafe.Pointer(&[]int32{virtioNetHdrLen}[0]))); errno != 0 {
+			offload = false
+		}
+	}
+	if offload {
+		flags := uintptr(tunOffloadCsum | tunOffloadTSO4 | tunOffloadTSO6)
+		if _, _, errno := unix.Syscall(unix.SYS_IOCTL, uintptr(fd), cTUNSETOFFLOAD, flags); errno != 0 {
+			offload = false
+		}
+	}
+	if !offload && req.Flags&cIFF_VNET_HDR != 0 {
+		// IFF_VNET_HDR is already attached to this fd, so the kernel would
+		// keep prepending a virtio_net_hdr; reopen as a plain device instead.
+		unix.Close(fd)
+		return CreateTUN(name, mtu, false)
+	}
+	t.offloadEnabled = offload
+
+	if err := setMTU(name, mtu); err != nil {
+		unix.Close(fd)
+		return nil, err
+	}
+
+	return t, nil
+}
+
+func setMTU(name string, mtu int) error {
+	sock, err := unix.Socket(unix.AF_INET, unix.SOCK_DGRAM, 0)
+	if err != nil {
+		return fmt.Errorf("socket for SIOCSIFMTU: %w", err)
+	}
+	defer unix.Close(sock)
+
+	var req ifReqMTU
+	copy(req.Name[:], name)
+	req.MTU = int32(mtu)
+	if _, _, errno := unix.Syscall(unix.SYS_IOCTL, uintptr(sock), unix.SIOCSIFMTU, uintptr(unsafe.Pointer(&req))); errno != 0 {
+		return fmt.Errorf("ioctl(SIOCSIFMTU) %s: %w", name, errno)
+	}
+	return nil
+}
+
+// Read fills as many of bufs as are already queued on the fd without
+// blocking, after waiting for at least one to arrive. Returning more than
+// one packet per call matters for offload mode: groCoalesce can only merge
+// segments that land in the same batch, so a Read that always stopped at
+// n=1 made the GRO coalescer upstream dead code.
+//
+// Only when t.offloadEnabled did CreateTUN negotiate IFF_VNET_HDR, so only
+// then does the kernel actually prepend a virtio_net_hdr; reading the raw
+// packet straight into bufs[n] from index 0 in the non-offload case (the
+// default, no-`-offload` mode) would read a header that was never sent and
+// shift every packet's contents.
+func (t *linuxTUN) Read(bufs [][]byte, sizes []int, offset int) (int, error) {
+	n := 0
+	for n < len(bufs) {
+		var dst []byte
+		if t.offloadEnabled {
+			dst = bufs[n]
+		} else {
+			dst = bufs[n][offset:]
+		}
+		r, err := unix.Read(t.fd, dst)
+		if err != nil {
+			if err == unix.EAGAIN || err == unix.EWOULDBLOCK {
+				if n > 0 {
+					// Already have a batch; don't block for more.
+					break
+				}
+				if perr := t.waitReadable(); perr != nil {
+					return 0, perr
+				}
+				continue
+			}
+			if err == unix.EMSGSIZE {
+				// The kernel had a coalesced (GSO) super-frame ready that
+				// doesn't fit in bufs[n]; the caller needs a bigger buffer
+				// rather than a silently truncated packet.
+				if n > 0 {
+					break
+				}
+				return 0, ErrTooManySegments
+			}
+			return n, err
+		}
+		if t.offloadEnabled {
+			if r < offset {
+				sizes[n] = 0
+			} else {
+				sizes[n] = r - offset
+			}
+		} else {
+			sizes[n] = r
+		}
+		n++
+	}
+	return n, nil
+}
+
+// waitReadable blocks until t.fd has at least one packet ready, so Read's
+// batching loop doesn't busy-spin while waiting for the first packet.
+func (t *linuxTUN) waitReadable() error {
+	fds := []unix.PollFd{{Fd: int32(t.fd), Events: unix.POLLIN}}
+	for {
+		_, err := unix.Poll(fds, -1)
+		if err != nil {
+			if err == unix.EINTR {
+				continue
+			}
+			return err
+		}
+		return nil
+	}
+}
+
+func (t *linuxTUN) Write(bufs [][]byte, offset int) (int, error) {
+	var errs ErrorBatch
+	written := 0
+	for i, buf := range bufs {
+		out := buf
+		if !t.offloadEnabled {
+			// No IFF_VNET_HDR was negotiated, so the kernel expects the raw
+			// packet with no leading header; buf[0:offset) is just this
+			// package's reserved virtio_net_hdr space and was never meant
+			// to hit the wire.
+			out = buf[offset:]
+		}
+		if err := t.writeOne(out); err != nil {
+			errs = append(errs, fmt.Errorf("packet %d: %w", i, err))
+			continue
+		}
+		written++
+	}
+	if len(errs) > 0 {
+		return written, errs
+	}
+	return written, nil
+}
+
+// writeOne writes a single packet, retrying on EAGAIN: the fd is
+// non-blocking (see Read), so a full kernel queue must be waited out here
+// rather than surfaced as a spurious write failure.
+func (t *linuxTUN) writeOne(buf []byte) error {
+	for {
+		_, err := unix.Write(t.fd, buf)
+		if err == nil {
+			return nil
+		}
+		if err != unix.EAGAIN && err != unix.EWOULDBLOCK {
+			return err
+		}
+		if perr := t.waitWritable(); perr != nil {
+			return perr
+		}
+	}
+}
+
+// waitWritable blocks until t.fd can accept another write.
+func (t *linuxTUN) waitWritable() error {
+	fds := []unix.PollFd{{Fd: int32(t.fd), Events: unix.POLLOUT}}
+	for {
+		_, err := unix.Poll(fds, -1)
+		if err != nil {
+			if err == unix.EINTR {
+				continue
+			}
+			return err
+		}
+		return nil
+	}
+}
+
+func (t *linuxTUN) Close() error {
+	return unix.Close(t.fd)
+}
+
+// Offloaded reports whether the kernel actually accepted TUNSETOFFLOAD for
+// this device; CreateTUN may have silently fallen back to a plain device
+// even when offload was requested.
+func (t *linuxTUN) Offloaded() bool {
+	return t.offloadEnabled
+}
+
+// configureInterface assigns ip/cidr to name and brings it up using
+// NETLINK_ROUTE, rather than shelling out to the `ip` tool. mtu is ignored
+// here: CreateTUN already set it via SIOCSIFMTU.
+func configureInterface(name, ip, cidr string, mtu int) error {
+	iface, err := net.InterfaceByName(name)
+	if err != nil {
+		return fmt.Errorf("resolve ifindex for %s: %w", name, err)
+	}
+	idx := uint32(iface.Index)
+
+	prefixLen, err := parseCIDR(cidr)
+	if err != nil {
+		return err
+	}
+	addr, err := parseIPv4(ip)
+	if err != nil {
+		return err
+	}
+
+	sock, err := unix.Socket(unix.AF_NETLINK, unix.SOCK_RAW, unix.NETLINK_ROUTE)
+	if err != nil {
+		return fmt.Errorf("open netlink socket: %w", err)
+	}
+	defer unix.Close(sock)
+	if err := unix.Bind(sock, &unix.SockaddrNetlink{Family: unix.AF_NETLINK}); err != nil {
+		return fmt.Errorf("bind netlink socket: %w", err)
+	}
+
+	if err := netlinkAddAddr(sock, idx, addr, prefixLen); err != nil {
+		return fmt.Errorf("add address to %s: %w", name, err)
+	}
+	if err := netlinkLinkUp(sock, idx); err != nil {
+		return fmt.Errorf("bring %s up: %w", name, err)
+	}
+
+	log.Printf("Successfully set up TUN device %s with address %s/%d", name, ip, prefixLen)
+	return nil
+}
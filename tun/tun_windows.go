@@ -0,0 +1,239 @@
+//go:build windows
+
+package tun
+
+import (
+	"fmt"
+	"log"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+// Windows has no kernel TUN driver; CreateTUN instead drives the Wintun
+// driver (wintun.dll), the same one wireguard-go uses, via a minimal set
+// of bindings for the calls this package actually needs. Address/MTU
+// configuration goes through iphlpapi.dll the same way, rather than
+// golang.org/x/sys/windows (which doesn't wrap the IP Helper NET_LUID
+// calls) or shelling out to netsh.
+var (
+	wintunDLL                      = windows.NewLazySystemDLL("wintun.dll")
+	procWintunCreateAdapter        = wintunDLL.NewProc("WintunCreateAdapter")
+	procWintunCloseAdapter         = wintunDLL.NewProc("WintunCloseAdapter")
+	procWintunGetAdapterLUID       = wintunDLL.NewProc("WintunGetAdapterLUID")
+	procWintunStartSession         = wintunDLL.NewProc("WintunStartSession")
+	procWintunEndSession           = wintunDLL.NewProc("WintunEndSession")
+	procWintunReceivePacket        = wintunDLL.NewProc("WintunReceivePacket")
+	procWintunReleaseReceivePacket = wintunDLL.NewProc("WintunReleaseReceivePacket")
+	procWintunAllocateSendPacket   = wintunDLL.NewProc("WintunAllocateSendPacket")
+	procWintunSendPacket           = wintunDLL.NewProc("WintunSendPacket")
+
+	iphlpDLL                        = windows.NewLazySystemDLL("iphlpapi.dll")
+	procConvertInterfaceNameToLuid  = iphlpDLL.NewProc("ConvertInterfaceNameToLuidW")
+	procGetIfEntry2                 = iphlpDLL.NewProc("GetIfEntry2")
+	procSetIfEntry2                 = iphlpDLL.NewProc("SetIfEntry2")
+	procCreateUnicastIpAddressEntry = iphlpDLL.NewProc("CreateUnicastIpAddressEntry")
+)
+
+const wintunRingCapacity = 0x400000 // 4 MiB, the minimum Wintun accepts
+
+// windowsTUN wraps a Wintun adapter handle and an open session on it.
+type windowsTUN struct {
+	adapter uintptr
+	session uintptr
+	luid    uint64
+	name    string
+}
+
+// mibIfRow2 mirrors the fields of MIB_IF_ROW2 (netioapi.h) this package
+// reads/writes. The trailing pad is sized generously beyond the struct's
+// real ~1.3KB footprint so that GetIfEntry2/SetIfEntry2, which address it
+// by sizeof(MIB_IF_ROW2), can never write past the end of this mirror
+// even if a field was misremembered.
+type mibIfRow2 struct {
+	InterfaceLuid         uint64
+	InterfaceIndex        uint32
+	interfaceGUID         [16]byte
+	alias                 [257]uint16
+	description           [257]uint16
+	physicalAddressLength uint32
+	physicalAddress       [32]uint8
+	permanentPhysAddress  [32]uint8
+	Mtu                   uint32
+	ifType                uint32
+	tunnelType            uint32
+	mediaType             uint32
+	physicalMediumType    uint32
+	accessType            uint32
+	directionType         uint32
+	rest                  [256]byte // operational/status fields and 64-bit counters, unused here
+	pad                   [512]byte // defensive overrun margin, see doc comment above
+}
+
+func (r *mibIfRow2) ptr() uintptr { return uintptr(unsafe.Pointer(r)) }
+
+// CreateTUN brings up a Wintun adapter and session for name.
+func CreateTUN(name string, mtu int, offload bool) (Device, error) {
+	if offload {
+		log.Printf("Offload is not implemented on windows; ignoring -offload for %s", name)
+	}
+
+	namePtr, err := windows.UTF16PtrFromString(name)
+	if err != nil {
+		return nil, fmt.Errorf("encode adapter name: %w", err)
+	}
+	tunnelTypePtr, err := windows.UTF16PtrFromString("Tun")
+	if err != nil {
+		return nil, err
+	}
+
+	adapter, _, errno := procWintunCreateAdapter.Call(
+		uintptr(unsafe.Pointer(namePtr)),
+		uintptr(unsafe.Pointer(tunnelTypePtr)),
+		0, // request a random adapter GUID
+	)
+	if adapter == 0 {
+		return nil, fmt.Errorf("WintunCreateAdapter %s: %w", name, errno)
+	}
+
+	var luid uint64
+	procWintunGetAdapterLUID.Call(adapter, uintptr(unsafe.Pointer(&luid)))
+
+	session, _, errno := procWintunStartSession.Call(adapter, wintunRingCapacity)
+	if session == 0 {
+		procWintunCloseAdapter.Call(adapter)
+		return nil, fmt.Errorf("WintunStartSession %s: %w", name, errno)
+	}
+
+	t := &windowsTUN{adapter: adapter, session: session, luid: luid, name: name}
+
+	if err := setMTUByLUID(luid, mtu); err != nil {
+		log.Printf("Warning: could not pre-configure MTU for %s: %v", name, err)
+	}
+
+	return t, nil
+}
+
+func (t *windowsTUN) Read(bufs [][]byte, sizes []int, offset int) (int, error) {
+	var packetSize uint32
+	ptr, _, errno := procWintunReceivePacket.Call(t.session, uintptr(unsafe.Pointer(&packetSize)))
+	if ptr == 0 {
+		if errno == windows.ERROR_NO_MORE_ITEMS {
+			return 0, nil
+		}
+		return 0, fmt.Errorf("WintunReceivePacket: %w", errno)
+	}
+	defer procWintunReleaseReceivePacket.Call(t.session, ptr)
+
+	data := unsafe.Slice((*byte)(unsafe.Pointer(ptr)), packetSize)
+	copy(bufs[0][offset:], data)
+	sizes[0] = int(packetSize)
+	return 1, nil
+}
+
+func (t *windowsTUN) Write(bufs [][]byte, offset int) (int, error) {
+	var errs ErrorBatch
+	written := 0
+	for i, buf := range bufs {
+		payload := buf[offset:]
+		ptr, _, errno := procWintunAllocateSendPacket.Call(t.session, uintptr(len(payload)))
+		if ptr == 0 {
+			errs = append(errs, fmt.Errorf("packet %d: WintunAllocateSendPacket: %w", i, errno))
+			continue
+		}
+		dst := unsafe.Slice((*byte)(unsafe.Pointer(ptr)), len(payload))
+		copy(dst, payload)
+		procWintunSendPacket.Call(t.session, ptr)
+		written++
+	}
+	if len(errs) > 0 {
+		return written, errs
+	}
+	return written, nil
+}
+
+func (t *windowsTUN) Close() error {
+	procWintunEndSession.Call(t.session)
+	procWintunCloseAdapter.Call(t.adapter)
+	return nil
+}
+
+// setMTUByLUID is the LUID-addressed half of configureInterface, applying
+// the MTU as soon as the adapter exists so Wintun sessions never run
+// without one set.
+func setMTUByLUID(luid uint64, mtu int) error {
+	var row mibIfRow2
+	row.InterfaceLuid = luid
+	if ret, _, _ := procGetIfEntry2.Call(row.ptr()); ret != 0 {
+		return fmt.Errorf("GetIfEntry2: %w", windows.Errno(ret))
+	}
+	row.Mtu = uint32(mtu)
+	if ret, _, _ := procSetIfEntry2.Call(row.ptr()); ret != 0 {
+		return fmt.Errorf("SetIfEntry2: %w", windows.Errno(ret))
+	}
+	return nil
+}
+
+// mibUnicastIPAddressRow mirrors the fields of MIB_UNICASTIPADDRESS_ROW
+// (netioapi.h) this package populates; see mibIfRow2's doc comment for why
+// it carries a defensive trailing pad.
+type mibUnicastIPAddressRow struct {
+	addressFamily uint16
+	addressPort   uint16
+	addressAddr   [4]byte
+	addressPad    [20]byte // rest of the SOCKADDR_INET union (IPv6 case)
+
+	InterfaceLUID      uint64
+	interfaceIndex     uint32
+	prefixOrigin       uint32
+	suffixOrigin       uint32
+	validLifetime      uint32
+	preferredLifetime  uint32
+	OnLinkPrefixLength uint8
+	skipAsSource       uint8
+	_                  uint16
+
+	pad [64]byte // defensive overrun margin, see mibIfRow2's doc comment
+}
+
+func (r *mibUnicastIPAddressRow) ptr() uintptr { return uintptr(unsafe.Pointer(r)) }
+
+// configureInterface assigns ip/cidr to the adapter backing name via
+// CreateUnicastIpAddressEntry instead of shelling out to netsh; it does not
+// change the adapter's up/down state. mtu is ignored: CreateTUN already set
+// it via setMTUByLUID.
+func configureInterface(name, ip, cidr string, mtu int) error {
+	namePtr, err := windows.UTF16PtrFromString(name)
+	if err != nil {
+		return fmt.Errorf("encode adapter name: %w", err)
+	}
+
+	var luid uint64
+	if ret, _, _ := procConvertInterfaceNameToLuid.Call(uintptr(unsafe.Pointer(namePtr)), uintptr(unsafe.Pointer(&luid))); ret != 0 {
+		return fmt.Errorf("ConvertInterfaceNameToLuid %s: %w", name, windows.Errno(ret))
+	}
+
+	prefixLen, err := parseCIDR(cidr)
+	if err != nil {
+		return err
+	}
+	addr, err := parseIPv4(ip)
+	if err != nil {
+		return err
+	}
+
+	var row mibUnicastIPAddressRow
+	row.addressFamily = windows.AF_INET
+	row.addressAddr = addr
+	row.InterfaceLUID = luid
+	row.OnLinkPrefixLength = uint8(prefixLen)
+	row.validLifetime = 0xffffffff     // infinite
+	row.preferredLifetime = 0xffffffff // infinite
+
+	if ret, _, _ := procCreateUnicastIpAddressEntry.Call(row.ptr()); ret != 0 {
+		return fmt.Errorf("CreateUnicastIpAddressEntry %s: %w", name, windows.Errno(ret))
+	}
+
+	log.Printf("Successfully set up TUN device %s with address %s/%d", name, ip, prefixLen)
+	return nil
+}